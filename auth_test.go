@@ -0,0 +1,43 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestExtractTokenPrecedence(t *testing.T) {
+	cases := []struct {
+		name   string
+		header string
+		query  string
+		proto  string
+		want   string
+	}{
+		{name: "authorization header wins", header: "Bearer abc", query: "qqq", proto: "ppp", want: "abc"},
+		{name: "falls back to query param", query: "qqq", proto: "ppp", want: "qqq"},
+		{name: "falls back to websocket protocol header", proto: "ppp, other", want: "ppp"},
+		{name: "no candidates", want: ""},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/ws", nil)
+			if tc.header != "" {
+				req.Header.Set("Authorization", tc.header)
+			}
+			if tc.query != "" {
+				q := req.URL.Query()
+				q.Set("token", tc.query)
+				req.URL.RawQuery = q.Encode()
+			}
+			if tc.proto != "" {
+				req.Header.Set("Sec-WebSocket-Protocol", tc.proto)
+			}
+
+			if got := extractToken(req); got != tc.want {
+				t.Errorf("extractToken() = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,71 @@
+// loadtest 打开 N 个 websocket 客户端，往聊天服务器里灌消息，
+// 方便在开发环境里把 chat_broadcast_latency_seconds 等直方图打出有意义的分布。
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func main() {
+	addr := flag.String("addr", "ws://127.0.0.1:12345/ws", "chat server websocket URL")
+	clients := flag.Int("clients", 50, "number of concurrent websocket clients")
+	duration := flag.Duration("duration", 30*time.Second, "how long each client keeps sending messages")
+	interval := flag.Duration("interval", 200*time.Millisecond, "delay between messages sent by a single client")
+	flag.Parse()
+
+	var wg sync.WaitGroup
+	for i := 0; i < *clients; i++ {
+		wg.Add(1)
+		go runClient(&wg, i, *addr, *duration, *interval)
+	}
+	wg.Wait()
+	log.Println("loadtest finished")
+}
+
+// runClient 建立一个连接，每隔 interval 发送一条消息，直到 duration 到期，
+// 同时丢弃收到的消息，只是为了不阻塞对端的写循环。
+func runClient(wg *sync.WaitGroup, id int, addr string, duration, interval time.Duration) {
+	defer wg.Done()
+
+	conn, _, err := websocket.DefaultDialer.Dial(addr, nil)
+	if err != nil {
+		log.Printf("client %d: dial failed: %v", id, err)
+		return
+	}
+	defer conn.Close()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	deadline := time.After(duration)
+
+	for {
+		select {
+		case <-deadline:
+			return
+		case <-done:
+			return
+		case <-ticker.C:
+			payload := fmt.Sprintf(`{"content":"hello from client %d"}`, id)
+			if err := conn.WriteMessage(websocket.TextMessage, []byte(payload)); err != nil {
+				log.Printf("client %d: write failed: %v", id, err)
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,313 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/wangheng-123/real_time_chat_app/metrics"
+)
+
+// Hub 取代了原来的 ClientManager，除了维护全部客户端之外，还按用户 ID 和房间 ID
+// 索引客户端，使 Message.Recipient 能够被用来做定向消息和房间广播。
+type Hub struct {
+	mu         sync.Mutex                  //保护 clients/users/rooms，因为它们会被 Hub.start 和各个 Client.read goroutine 并发读写
+	clients    map[*Client]bool            //所有连接的客户端
+	users      map[string]*Client          //按用户 ID 索引的客户端，用于定向消息
+	rooms      map[string]map[*Client]bool //按房间 ID 索引的客户端集合
+	register   chan *Client                //尝试注册的客户端
+	unregister chan *Client                //已销毁并等待删除的客户端
+	store      MessageStore                //消息的可插拔持久化存储
+
+	workQueue        chan workItem           //发布给工作者池的任务，由 start 以轮询方式派发
+	workerRegister   chan workerRegistration //尝试加入某个工作者池的客户端
+	workerUnregister chan workerRegistration //离开某个工作者池的客户端
+	workerPools      map[string][]*Client    //按池名索引的工作者客户端列表，只由 Hub.start 访问
+	workerIndex      map[string]*uint64      //每个池下一次派发时使用的轮询下标，只由 Hub.start 访问
+}
+
+// workItem 是发布到 Hub.workQueue 的一条任务，Pool 决定它被投递到哪个工作者池。
+type workItem struct {
+	Pool    string
+	Message []byte
+}
+
+// workerRegistration 携带一个工作者客户端及它所属的池名，用于 workerRegister/
+// workerUnregister 通道，和 register/unregister 一样只由 Hub.start 这一个 goroutine
+// 处理，避免从任意 goroutine 并发修改 workerPools。
+type workerRegistration struct {
+	Pool   string
+	Client *Client
+}
+
+// roomPrefix 标识一个 Recipient 是房间 ID 而不是用户 ID。
+const roomPrefix = "#"
+
+// 启动一个全局 Hub，store 默认使用内存环形缓冲区，保留最近 1000 条消息。
+var manager = Hub{
+	register:         make(chan *Client),
+	unregister:       make(chan *Client),
+	clients:          make(map[*Client]bool),
+	users:            make(map[string]*Client),
+	rooms:            make(map[string]map[*Client]bool),
+	store:            NewMemoryStore(1000),
+	workQueue:        make(chan workItem),
+	workerRegister:   make(chan workerRegistration),
+	workerUnregister: make(chan workerRegistration),
+	workerPools:      make(map[string][]*Client),
+	workerIndex:      make(map[string]*uint64),
+}
+
+// send 遍历每个客户端并广播消息，ignore 不为空时跳过该客户端，用于保留原有的上线/下线通知行为。
+// 客户端的 send 缓冲区已满时直接丢弃这个客户端，而不是阻塞在它身上等待。msgType 是这条消息
+// 真实的 Message.Type（chat/system/user_joined/...），用于给 chat_messages_total 打标签，
+// 而不是一律记成 chat。
+func (hub *Hub) send(message []byte, ignore *Client, msgType string) {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+
+	for conn := range hub.clients {
+		if conn != ignore {
+			hub.deliverLocked(conn, message, msgType)
+		}
+	}
+}
+
+// sendToUser 把消息定向发送给指定用户 ID 的客户端，用户不在线时静默丢弃。
+func (hub *Hub) sendToUser(userID string, message []byte, msgType string) {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+
+	if conn, ok := hub.users[userID]; ok {
+		hub.deliverLocked(conn, message, msgType)
+	}
+}
+
+// sendToRoom 把消息广播给房间内的所有成员，ignore 不为空时跳过该客户端。
+func (hub *Hub) sendToRoom(roomID string, message []byte, ignore *Client, msgType string) {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+
+	for conn := range hub.rooms[roomID] {
+		if conn != ignore {
+			hub.deliverLocked(conn, message, msgType)
+		}
+	}
+}
+
+// deliverLocked 尝试把消息放进客户端的 send 缓冲区；缓冲区已满说明这个客户端读取得太慢，
+// 直接把它清理掉，而不是阻塞调用方等待缓冲区腾出空间。调用方必须已经持有 hub.mu。
+func (hub *Hub) deliverLocked(conn *Client, message []byte, msgType string) {
+	select {
+	case conn.send <- message:
+		metrics.MessagesTotal.WithLabelValues("out", msgType).Inc()
+	default:
+		if _, ok := hub.clients[conn]; ok {
+			hub.removeClientLocked(conn)
+			metrics.DroppedClientsTotal.Inc()
+			logger.Warn("dropped slow client", "client_id", conn.id)
+		}
+	}
+}
+
+// removeClientLocked 把客户端从所有房间、用户索引和客户端映射中移除并关闭它的 send
+// 通道。调用方必须已经持有 hub.mu；这里不走 leaveRoom/leaveAllRooms，是因为它们自己
+// 会再次加锁，而是否发送 user_left 事件对于一个已经读取迟缓、即将被丢弃的客户端并不重要。
+func (hub *Hub) removeClientLocked(conn *Client) {
+	for roomID := range hub.rooms {
+		delete(hub.rooms[roomID], conn)
+	}
+	// 只有 hub.users[conn.id] 还指向这个 conn 才能删除，否则同一个用户 ID 的新连接
+	// 早已覆盖了旧条目，这里删的就是新连接，而不是正在断开的这个。
+	if hub.users[conn.id] == conn {
+		delete(hub.users, conn.id)
+	}
+	close(conn.send)
+	delete(hub.clients, conn)
+}
+
+// dispatch 根据 Message.Recipient 选择投递策略：定向用户、定向房间，或者退化为全局广播。
+func (hub *Hub) dispatch(msg Message, jsonMessage []byte, sender *Client) {
+	switch {
+	case msg.Recipient == "":
+		hub.send(jsonMessage, sender, msg.Type)
+	case strings.HasPrefix(msg.Recipient, roomPrefix):
+		hub.sendToRoom(msg.Recipient, jsonMessage, sender, msg.Type)
+	default:
+		hub.sendToUser(msg.Recipient, jsonMessage, msg.Type)
+	}
+}
+
+// joinRoom 把客户端加入房间，并向房间内其余成员广播 user_joined 事件。
+func (hub *Hub) joinRoom(c *Client, roomID string) {
+	hub.mu.Lock()
+	if hub.rooms[roomID] == nil {
+		hub.rooms[roomID] = make(map[*Client]bool)
+	}
+	hub.rooms[roomID][c] = true
+	hub.mu.Unlock()
+
+	event := newMessage(c.id, roomID, fmt.Sprintf("%s joined %s", c.id, roomID), "user_joined")
+	jsonMessage, _ := json.Marshal(&event)
+	hub.sendToRoom(roomID, jsonMessage, c, event.Type)
+}
+
+// leaveRoom 把客户端移出房间，并向房间内其余成员广播 user_left 事件。
+func (hub *Hub) leaveRoom(c *Client, roomID string) {
+	hub.mu.Lock()
+	_, wasMember := hub.rooms[roomID][c]
+	if wasMember {
+		delete(hub.rooms[roomID], c)
+	}
+	hub.mu.Unlock()
+	if !wasMember {
+		return
+	}
+
+	event := newMessage(c.id, roomID, fmt.Sprintf("%s left %s", c.id, roomID), "user_left")
+	jsonMessage, _ := json.Marshal(&event)
+	hub.sendToRoom(roomID, jsonMessage, c, event.Type)
+}
+
+// leaveAllRooms 在客户端断开连接时把它从所有房间中移除，避免房间里残留失效的客户端。
+func (hub *Hub) leaveAllRooms(c *Client) {
+	hub.mu.Lock()
+	var roomIDs []string
+	for roomID, members := range hub.rooms {
+		if members[c] {
+			roomIDs = append(roomIDs, roomID)
+		}
+	}
+	hub.mu.Unlock()
+
+	for _, roomID := range roomIDs {
+		hub.leaveRoom(c, roomID)
+	}
+}
+
+// listUsers 返回当前在线的用户 ID 列表，供 list_users 控制消息使用。
+func (hub *Hub) listUsers() []string {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+
+	userIDs := make([]string, 0, len(hub.users))
+	for userID := range hub.users {
+		userIDs = append(userIDs, userID)
+	}
+	return userIDs
+}
+
+// listRooms 返回当前存在成员的房间 ID 列表，供 list_rooms 控制消息使用。
+func (hub *Hub) listRooms() []string {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+
+	roomIDs := make([]string, 0, len(hub.rooms))
+	for roomID, members := range hub.rooms {
+		if len(members) > 0 {
+			roomIDs = append(roomIDs, roomID)
+		}
+	}
+	return roomIDs
+}
+
+// registerWorker 把客户端加入名为 pool 的工作者池，只应从 Hub.start 调用。
+func (hub *Hub) registerWorker(pool string, c *Client) {
+	hub.workerPools[pool] = append(hub.workerPools[pool], c)
+	if hub.workerIndex[pool] == nil {
+		hub.workerIndex[pool] = new(uint64)
+	}
+}
+
+// unregisterWorker 把客户端从它所在的工作者池中移除，只应从 Hub.start 调用。
+func (hub *Hub) unregisterWorker(pool string, c *Client) {
+	workers := hub.workerPools[pool]
+	for i, w := range workers {
+		if w == c {
+			hub.workerPools[pool] = append(workers[:i], workers[i+1:]...)
+			return
+		}
+	}
+}
+
+// dispatchWork 以轮询方式把一个任务投递给 pool 中的下一个工作者，跳过 send 缓冲区
+// 已满的工作者并尝试下一个，直到找到一个能接收的工作者或把全部工作者都试过一遍。
+func (hub *Hub) dispatchWork(item workItem) {
+	workers := hub.workerPools[item.Pool]
+	if len(workers) == 0 {
+		return
+	}
+
+	counter := hub.workerIndex[item.Pool]
+	start := int(atomic.AddUint64(counter, 1)-1) % len(workers)
+	for i := 0; i < len(workers); i++ {
+		idx := (start + i) % len(workers)
+		select {
+		case workers[idx].send <- item.Message:
+			metrics.MessagesTotal.WithLabelValues("out", MessageTypeWork).Inc()
+			return
+		default:
+		}
+	}
+}
+
+// 管理客户端
+// hub.register:每次通道有数据时，客户端都会被添加到 hub 管理的可用客户端映射以及用户索引中。
+// 添加客户端后，JSON 消息将发送到所有其他客户端，不包括刚刚连接的客户端。
+//
+// hub.unregister:如果客户端因任何原因断开连接，通道将具有数据。断开连接的客户端会被从所有
+// 房间、用户索引和客户端映射中移除，宣布套接字消失的消息将发送到所有剩余的连接。
+//
+// 聊天消息仍然由 Client.read 直接调用 hub.dispatch 广播、不经过这个 goroutine，
+// join/leave/list 等控制消息也是在各自的 Client.read goroutine 里直接调用 hub 的方法——
+// 这些方法和这里的 register/unregister 处理都会对 hub.clients/users/rooms 加 hub.mu，
+// 所以可以安全地并发执行，而不是依赖"只有这个 goroutine 碰这些映射"的假设。
+func (hub *Hub) start() {
+	for {
+		select {
+		case conn := <-hub.register:
+			hub.mu.Lock()
+			hub.clients[conn] = true
+			hub.users[conn.id] = conn
+			hub.mu.Unlock()
+			msg := newMessage("", "", "/A new socket has connected.", MessageTypeSystem)
+			jsonMessage, _ := json.Marshal(&msg)
+			hub.send(jsonMessage, conn, msg.Type)
+		case conn := <-hub.unregister:
+			hub.mu.Lock()
+			_, ok := hub.clients[conn]
+			hub.mu.Unlock()
+			if !ok {
+				continue
+			}
+
+			hub.leaveAllRooms(conn)
+
+			hub.mu.Lock()
+			_, ok = hub.clients[conn]
+			if ok {
+				if hub.users[conn.id] == conn {
+					delete(hub.users, conn.id)
+				}
+				close(conn.send)
+				delete(hub.clients, conn)
+			}
+			hub.mu.Unlock()
+			if !ok {
+				continue
+			}
+
+			msg := newMessage("", "", "/A socket has disconnected.", MessageTypeSystem)
+			jsonMessage, _ := json.Marshal(&msg)
+			hub.send(jsonMessage, conn, msg.Type)
+		case item := <-hub.workQueue:
+			hub.dispatchWork(item)
+		case reg := <-hub.workerRegister:
+			hub.registerWorker(reg.Pool, reg.Client)
+		case reg := <-hub.workerUnregister:
+			hub.unregisterWorker(reg.Pool, reg.Client)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import "testing"
+
+func newTestWorkerHub(pool string, n int) (*Hub, []*Client) {
+	hub := &Hub{workerPools: make(map[string][]*Client), workerIndex: make(map[string]*uint64)}
+	workers := make([]*Client, n)
+	for i := range workers {
+		workers[i] = &Client{send: make(chan []byte, 1)}
+		hub.registerWorker(pool, workers[i])
+	}
+	return hub, workers
+}
+
+func TestDispatchWorkRoundRobin(t *testing.T) {
+	hub, workers := newTestWorkerHub("jobs", 3)
+
+	for i := 0; i < 3; i++ {
+		hub.dispatchWork(workItem{Pool: "jobs", Message: []byte("msg")})
+	}
+
+	for i, w := range workers {
+		if len(w.send) != 1 {
+			t.Errorf("worker %d: got %d queued messages, want 1", i, len(w.send))
+		}
+	}
+}
+
+func TestDispatchWorkSkipsFullBuffer(t *testing.T) {
+	hub, workers := newTestWorkerHub("jobs", 2)
+	workers[0].send <- []byte("already full")
+
+	hub.dispatchWork(workItem{Pool: "jobs", Message: []byte("msg")})
+
+	if len(workers[1].send) != 1 {
+		t.Errorf("expected the task to skip the full worker and land on the next one")
+	}
+}
+
+func TestDispatchWorkEmptyPoolIsNoop(t *testing.T) {
+	hub := &Hub{workerPools: make(map[string][]*Client), workerIndex: make(map[string]*uint64)}
+	hub.dispatchWork(workItem{Pool: "jobs", Message: []byte("msg")})
+}
+
+func TestRemoveClientLockedKeepsNewerConnectionForSameUser(t *testing.T) {
+	hub := &Hub{
+		clients: make(map[*Client]bool),
+		users:   make(map[string]*Client),
+		rooms:   make(map[string]map[*Client]bool),
+	}
+
+	oldConn := &Client{id: "alice", send: make(chan []byte, 1)}
+	newConn := &Client{id: "alice", send: make(chan []byte, 1)}
+	hub.clients[oldConn] = true
+	hub.clients[newConn] = true
+	hub.users["alice"] = oldConn
+	hub.users["alice"] = newConn // newConn reconnected before oldConn was torn down
+
+	hub.removeClientLocked(oldConn)
+
+	if hub.users["alice"] != newConn {
+		t.Fatalf("removing the stale connection evicted the live one: hub.users[\"alice\"] = %v, want newConn", hub.users["alice"])
+	}
+}
@@ -2,150 +2,312 @@ package main
 
 import (
 	"encoding/json"
-	"fmt"
 	"github.com/gorilla/websocket"
 	uuid "github.com/satori/go.uuid"
+	"log/slog"
 	"net/http"
+	"os"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/wangheng-123/real_time_chat_app/metrics"
 )
 
-type ClientManager struct {
-	clients    map[*Client]bool //所有连接的客户端
-	broadcast  chan []byte      //与所有连接的客户端广播的消息
-	register   chan *Client     //尝试注册的客户端
-	unregister chan *Client     //已销毁并等待删除的客户端
-}
+// 历史记录消息类型常量，Type 字段用来区分普通聊天消息、系统消息与历史回放消息。
+const (
+	MessageTypeChat    = "chat"
+	MessageTypeSystem  = "system"
+	MessageTypeHistory = "history"
+)
+
+// historyReplayCount 是新客户端连接时回放的历史消息条数。
+const historyReplayCount = 50
+
+// sendBufferSize 是 Client.send 的缓冲区大小，网络较慢的客户端在缓冲区耗尽前
+// 不会阻塞 manager 的广播 goroutine。
+const sendBufferSize = 256
+
+// 以下常量沿用 gorilla/websocket 官方聊天示例的读写心跳参数：
+// pongWait 是等待下一个 pong 的最长时间，pingPeriod 必须小于 pongWait，
+// 以便在对端超时之前发出下一个 ping；writeDeadline 是每次写操作的超时时间；
+// maxMessageSize 限制单条消息的大小，防止恶意或异常客户端占用过多内存。
+const (
+	writeDeadline  = 10 * time.Second
+	pongWait       = 60 * time.Second
+	pingPeriod     = (pongWait * 9) / 10
+	maxMessageSize = 8192
+)
+
+// authenticator 在升级为 WebSocket 连接之前对请求进行身份验证，默认使用
+// NoopAuthenticator 保留本地开发时的开放行为，生产环境应替换为 JWTAuthenticator。
+var authenticator Authenticator = NoopAuthenticator{}
+
+// logger 是全局结构化日志记录器，取代此前分散在各处的 fmt.Println。和连接相关的日志用
+// client_id 标识客户端（认证失败时身份未知，只记 remote_addr）；remote_addr 只出现在
+// 认证、升级和建立连接的日志里，type 只出现在消息持久化失败的日志里。
+var logger = slog.New(slog.NewJSONHandler(os.Stdout, nil))
 
 type Client struct {
-	id     string          //唯一的 ID
-	socket *websocket.Conn //一个套接字连接
-	send   chan []byte     //一条等待发送的消息
+	id      string          //唯一的 ID，经 Authenticator 认证后的用户 ID
+	socket  *websocket.Conn //一个套接字连接
+	send    chan []byte     //一条等待发送的消息
+	claims  map[string]any  //Authenticator 返回的令牌声明，供房间/定向消息等下游鉴权使用
+	onClose func()          //连接关闭时的额外清理逻辑，worker 模式用它把客户端移出工作者池
+	worker  bool            //true 表示这是通过 /ws/worker 注册的工作者，不参与广播/房间/历史
 }
 
 type Message struct {
-	Sender    string `json:"sender,omitempty"`    //消息发送者
-	Recipient string `json:"recipient,omitempty"` //接收消息的人员
-	Content   string `json:"content,omitempty"`   //消息的实际内容的信息
+	ID        string    `json:"id,omitempty"`        //消息的唯一 ID，用于翻页与去重
+	Sender    string    `json:"sender,omitempty"`    //消息发送者
+	Recipient string    `json:"recipient,omitempty"` //接收消息的人员
+	Content   string    `json:"content,omitempty"`   //消息的实际内容的信息
+	Type      string    `json:"type,omitempty"`      //消息类型：chat、system、history、user_joined、user_left、list_users、list_rooms 或 work
+	Timestamp time.Time `json:"timestamp,omitempty"` //消息产生的时间
 }
 
-//启动一个全局ClientManager
-var manager = ClientManager{
-	broadcast:  make(chan []byte),
-	register:   make(chan *Client),
-	unregister: make(chan *Client),
-	clients:    make(map[*Client]bool),
+// controlMessage 是客户端发来的、不需要转发的控制指令：历史翻页、加入/离开房间，
+// 以及查询当前在线用户与房间列表。
+type controlMessage struct {
+	Type   string `json:"type"`
+	Before string `json:"before"`
+	Limit  int    `json:"limit"`
+	Room   string `json:"room"`
 }
 
-//manager.send:为了保存重复的代码，创建了一个方法来遍历每个客户端
-func (manager *ClientManager) send(message []byte, ignore *Client) {
-	for conn := range manager.clients {
-		if conn != ignore {
-			conn.send <- message
-		}
+// newMessage 构造一条带唯一 ID 与时间戳的消息。
+func newMessage(sender, recipient, content, msgType string) Message {
+	return Message{
+		ID:        uuid.NewV4().String(),
+		Sender:    sender,
+		Recipient: recipient,
+		Content:   content,
+		Type:      msgType,
+		Timestamp: time.Now(),
 	}
 }
 
-//服务器将使用三个 goroutine，一个用于管理客户端，一个用于读取 websocket 数据，一个用于写入 websocket 数据
-
-//管理客户端
-//manager.register:每次通道有数据时，客户端都会被添加到由客户端管理器管理的可用客户端映射中。添加客户端后，
-//JSON 消息将发送到所有其他客户端，不包括刚刚连接的客户端。
-//
-//manager.unregister:如果客户端因任何原因断开连接，通道将具有数据。断开连接的客户端中的通道数据将被关闭，
-//客户端将从客户端管理器中删除。宣布套接字消失的消息将发送到所有剩余的连接。
+// 现在我们可以探索用于读取从客户端发送的 websocket 数据的 goroutine。
+// 此 goroutine的重点是读取套接字数据并交给 manager.dispatch 做进一步的编排。
+// 如果读取 websocket 数据时出错，则可能意味着客户端已断开连接。如果是这种情况，我们需要从服务器中注销客户端。
 //
-//manager.broadcast:如果通道有数据，则意味着我们正在尝试发送和接收消息。我们希望遍历每个托管客户端，
-//将消息发送给每个客户端。如果由于某种原因通道堵塞或无法发送消息，我们假设客户端已断开连接，我们将删除它们。
-func (manager *ClientManager) start() {
-	for {
-		select {
-		case conn := <-manager.register:
-			manager.clients[conn] = true
-			jsonMessage, _ := json.Marshal(&Message{Content: "/A new socket has connected."})
-			manager.send(jsonMessage, conn)
-		case conn := <-manager.unregister:
-			if _, ok := manager.clients[conn]; ok {
-				close(conn.send)
-				delete(manager.clients, conn)
-				jsonMessage, _ := json.Marshal(&Message{Content: "/A socket has disconnected."})
-				manager.send(jsonMessage, conn)
-			}
-		case message := <-manager.broadcast:
-			for conn := range manager.clients {
-				select {
-				case conn.send <- message:
-				default:
-					close(conn.send)
-					delete(manager.clients, conn)
-				}
-			}
-		}
-	}
-}
-
-//现在我们可以探索用于读取从客户端发送的 websocket 数据的 goroutine。
-//此 goroutine的重点是读取套接字数据并将其添加到 manager.broadcast 中以进行进一步的编排。
-//如果读取 websocket 数据时出错，则可能意味着客户端已断开连接。如果是这种情况，我们需要从服务器中注销客户端。
+// SetReadLimit 防止单条消息过大占用内存，SetReadDeadline 配合 SetPongHandler
+// 在每次收到 pong 时刷新，只要客户端还能正常响应 ping，读超时就不会触发。
 func (c *Client) read() {
 	defer func() {
 		manager.unregister <- c
 		c.socket.Close()
 	}()
 
+	c.socket.SetReadLimit(maxMessageSize)
+	c.socket.SetReadDeadline(time.Now().Add(pongWait))
+	c.socket.SetPongHandler(func(string) error {
+		c.socket.SetReadDeadline(time.Now().Add(pongWait))
+		return nil
+	})
+
 	for {
-		_, message, err := c.socket.ReadMessage()
+		_, raw, err := c.socket.ReadMessage()
 		if err != nil {
 			manager.unregister <- c
 			c.socket.Close()
 			break
 		}
-		jsonMessage, _ := json.Marshal(&Message{Sender: c.id, Content: string(message)})
-		manager.broadcast <- jsonMessage
+		if c.worker {
+			// worker 客户端只消费 workQueue 派给它的任务，自己发上来的消息不参与
+			// 广播/房间/历史，这里直接丢弃。
+			metrics.MessagesTotal.WithLabelValues("in", MessageTypeWork).Inc()
+			continue
+		}
+
+		var ctrl controlMessage
+		if json.Unmarshal(raw, &ctrl) == nil && c.handleControlMessage(ctrl) {
+			metrics.MessagesTotal.WithLabelValues("in", ctrl.Type).Inc()
+			continue
+		}
+
+		var incoming Message
+		if err := json.Unmarshal(raw, &incoming); err != nil {
+			incoming = Message{}
+		}
+		msg := newMessage(c.id, incoming.Recipient, string(raw), MessageTypeChat)
+		if incoming.Content != "" {
+			msg.Content = incoming.Content
+		}
+		metrics.MessagesTotal.WithLabelValues("in", msg.Type).Inc()
+		if err := manager.store.Append(msg); err != nil {
+			logger.Error("failed to persist message", "client_id", c.id, "type", msg.Type, "error", err)
+		}
+		jsonMessage, _ := json.Marshal(&msg)
+
+		start := time.Now()
+		manager.dispatch(msg, jsonMessage, c)
+		metrics.BroadcastLatencySeconds.Observe(time.Since(start).Seconds())
+	}
+}
+
+// handleControlMessage 处理 join、leave、list_users、list_rooms 和 history 这些
+// 不需要转发的控制指令，返回 true 表示该条消息已被处理，不应继续当作聊天消息广播。
+func (c *Client) handleControlMessage(ctrl controlMessage) bool {
+	switch ctrl.Type {
+	case MessageTypeHistory:
+		c.sendHistory(ctrl)
+	case "join":
+		manager.joinRoom(c, ctrl.Room)
+	case "leave":
+		manager.leaveRoom(c, ctrl.Room)
+	case "list_users":
+		c.sendList("list_users", manager.listUsers())
+	case "list_rooms":
+		c.sendList("list_rooms", manager.listRooms())
+	default:
+		return false
 	}
+	return true
 }
 
-//c.send:如果通道有数据，我们会尝试发送消息。如果由于某种原因通道不正常，我们将向客户端发送断开连接消息。
+// sendList 把在线用户或房间列表序列化后发给发起请求的这一个客户端。
+func (c *Client) sendList(listType string, items []string) {
+	payload, _ := json.Marshal(items)
+	msg := newMessage("", "", string(payload), listType)
+	jsonMessage, _ := json.Marshal(&msg)
+	c.send <- jsonMessage
+}
+
+// sendHistory 响应客户端的 {"type":"history","before":"<msgID>","limit":50} 翻页请求，
+// 只把更早的历史消息发给发起请求的这一个客户端，不会广播给其他人。
+func (c *Client) sendHistory(ctrl controlMessage) {
+	limit := ctrl.Limit
+	if limit <= 0 {
+		limit = historyReplayCount
+	}
+
+	messages, err := manager.store.Before(ctrl.Before, limit)
+	if err != nil {
+		logger.Error("failed to load history page", "client_id", c.id, "error", err)
+		return
+	}
+
+	for _, msg := range messages {
+		msg.Type = MessageTypeHistory
+		jsonMessage, _ := json.Marshal(&msg)
+		c.send <- jsonMessage
+	}
+}
+
+// c.send:如果通道有数据，我们会尝试发送消息。如果由于某种原因通道不正常，我们将向客户端发送断开连接消息。
+// 一个 ticker 每隔 pingPeriod 发送一次 PingMessage 作为心跳；每次写操作之前都会
+// 调用 SetWriteDeadline，避免慢客户端无限期占住这个 goroutine。排队的多条消息
+// 通过 NextWriter 合并进同一个帧里一次性发送，减少系统调用次数。
 func (c *Client) write() {
+	ticker := time.NewTicker(pingPeriod)
 	defer func() {
+		ticker.Stop()
 		c.socket.Close()
+		metrics.ConnectedClients.Dec()
+		logger.Info("client disconnected", "client_id", c.id)
+		if c.onClose != nil {
+			c.onClose()
+		}
 	}()
 
 	for {
 		select {
 		case message, ok := <-c.send:
+			c.socket.SetWriteDeadline(time.Now().Add(writeDeadline))
 			if !ok {
 				c.socket.WriteMessage(websocket.CloseMessage, []byte{})
 				return
 			}
 
-			c.socket.WriteMessage(websocket.TextMessage, message)
+			w, err := c.socket.NextWriter(websocket.TextMessage)
+			if err != nil {
+				return
+			}
+			w.Write(message)
+
+			for n := len(c.send); n > 0; n-- {
+				w.Write([]byte{'\n'})
+				w.Write(<-c.send)
+			}
+
+			if err := w.Close(); err != nil {
+				return
+			}
+		case <-ticker.C:
+			c.socket.SetWriteDeadline(time.Now().Add(writeDeadline))
+			if err := c.socket.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
 		}
 	}
 }
 
-//HTTP 请求使用 websocket 库升级到 websocket 请求。通过添加 我们可以接受来自外部域的请求，
-//从而消除跨源资源共享 （CORS） 错误。CheckOrigin
-//建立连接时，将创建客户端并生成唯一 ID。如前所述，此客户端已注册到服务器。
-//客户端注册后，将触发读取和写入 goroutine。
+// HTTP 请求先经过 authenticator 验证身份，失败则直接返回 401，不会升级为 websocket 请求，
+// 避免建立连接后再补做鉴权。验证通过后再使用 websocket 库升级请求，通过添加 CheckOrigin 我们
+// 可以接受来自外部域的请求，从而消除跨源资源共享（CORS）错误。
+// 建立连接时，将使用认证得到的用户 ID 创建客户端。如前所述，此客户端已注册到服务器。
+// 客户端注册后，将触发读取和写入 goroutine。
 func wsPage(res http.ResponseWriter, req *http.Request) {
+	userID, claims, err := authenticator.Authenticate(req)
+	if err != nil {
+		logger.Warn("authentication failed", "remote_addr", req.RemoteAddr, "error", err)
+		http.Error(res, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
 	conn, error := (&websocket.Upgrader{CheckOrigin: func(r *http.Request) bool { return true }}).Upgrade(res, req, nil)
 	if error != nil {
+		metrics.UpgradeErrorsTotal.Inc()
+		logger.Error("websocket upgrade failed", "client_id", userID, "remote_addr", req.RemoteAddr, "error", error)
 		http.NotFound(res, req)
 		return
 	}
-	client := &Client{id: uuid.NewV4().String(), socket: conn, send: make(chan []byte)}
+	client := &Client{id: userID, socket: conn, send: make(chan []byte, sendBufferSize), claims: claims}
+	logger.Info("client connected", "client_id", client.id, "remote_addr", req.RemoteAddr)
+	metrics.ConnectedClients.Inc()
 
+	client.replayHistory()
 	manager.register <- client
 
 	go client.read()
 	go client.write()
 }
 
-//那么我们如何开始这些goroutine中的每一个呢？服务器 goroutine 将在我们启动服务器时启动，
-//其他每个 goroutines 将在有人连接时启动。
-//我们在端口 12345 上启动服务器，它有一个只能通过 websocket 连接访问的端点。
+// replayHistory 在客户端加入广播池之前，把最近的 historyReplayCount 条消息单独发给它，
+// 这样重新连接的客户端能补上离线期间错过的消息。
+func (c *Client) replayHistory() {
+	messages, err := manager.store.Tail(historyReplayCount)
+	if err != nil {
+		logger.Error("failed to load history", "client_id", c.id, "error", err)
+		return
+	}
+
+	for _, msg := range messages {
+		msg.Type = MessageTypeHistory
+		jsonMessage, err := json.Marshal(&msg)
+		if err != nil {
+			continue
+		}
+		c.socket.SetWriteDeadline(time.Now().Add(writeDeadline))
+		if err := c.socket.WriteMessage(websocket.TextMessage, jsonMessage); err != nil {
+			logger.Error("failed to replay history", "client_id", c.id, "error", err)
+			return
+		}
+	}
+}
+
+// 那么我们如何开始这些goroutine中的每一个呢？服务器 goroutine 将在我们启动服务器时启动，
+// 其他每个 goroutines 将在有人连接时启动。/metrics 单独注册在同一个 mux 上，
+// 供 Prometheus 采集 chat_* 系列指标。
+// 我们在端口 12345 上启动服务器：/ws 和 /ws/worker 是 websocket 端点，/work/publish
+// 接受普通的 HTTP POST 请求，/metrics 供 Prometheus 采集。
 func main() {
-	fmt.Println("Starting application...")
+	logger.Info("starting application")
+	configureAuthenticator()
 	go manager.start()
 	http.HandleFunc("/ws", wsPage)
+	http.HandleFunc("/ws/worker", wsWorkerPage)
+	http.HandleFunc("/work/publish", handlePublishWork)
+	http.Handle("/metrics", promhttp.Handler())
 	http.ListenAndServe(":12345", nil)
 }
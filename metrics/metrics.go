@@ -0,0 +1,40 @@
+// Package metrics 暴露聊天服务器的 Prometheus 指标，供 /metrics 端点采集。
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	// ConnectedClients 记录当前在线的客户端数量。
+	ConnectedClients = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "chat_connected_clients",
+		Help: "Number of websocket clients currently connected.",
+	})
+
+	// MessagesTotal 按方向（in/out）和消息类型统计处理过的消息条数。
+	MessagesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "chat_messages_total",
+		Help: "Total number of chat messages processed, labeled by direction and type.",
+	}, []string{"direction", "type"})
+
+	// BroadcastLatencySeconds 衡量从消息进入广播到最后一个客户端收到为止的耗时。
+	BroadcastLatencySeconds = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "chat_broadcast_latency_seconds",
+		Help:    "Time from a message entering dispatch to the last client's send channel receiving it.",
+		Buckets: prometheus.DefBuckets,
+	})
+
+	// DroppedClientsTotal 记录因 send 缓冲区已满而被清理掉的客户端数量。
+	DroppedClientsTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "chat_dropped_clients_total",
+		Help: "Total number of clients dropped because their send buffer was full.",
+	})
+
+	// UpgradeErrorsTotal 记录 websocket 升级失败的次数。
+	UpgradeErrorsTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "chat_upgrade_errors_total",
+		Help: "Total number of failed websocket upgrade attempts.",
+	})
+)
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// MessageStore 是消息持久化的可插拔接口，Append 在客户端发来的每条聊天消息分发之前调用
+// （系统消息和上下线事件不写入），Tail 在新客户端连接时调用，用于取出最近的历史记录。
+type MessageStore interface {
+	Append(msg Message) error
+	Tail(n int) ([]Message, error)
+	//Before 返回在 beforeID 对应的消息之前的最多 limit 条消息，用于翻页加载更早的历史。
+	Before(beforeID string, limit int) ([]Message, error)
+}
+
+// MemoryStore 是基于内存环形缓冲区的 MessageStore 实现，重启后历史记录会丢失，
+// 适合本地开发或不需要跨进程持久化的部署。
+type MemoryStore struct {
+	mu       sync.Mutex
+	messages []Message
+	capacity int
+}
+
+// NewMemoryStore 创建一个容量为 capacity 的内存历史记录存储。
+func NewMemoryStore(capacity int) *MemoryStore {
+	return &MemoryStore{capacity: capacity}
+}
+
+func (s *MemoryStore) Append(msg Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.messages = append(s.messages, msg)
+	if len(s.messages) > s.capacity {
+		s.messages = s.messages[len(s.messages)-s.capacity:]
+	}
+	return nil
+}
+
+func (s *MemoryStore) Tail(n int) ([]Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if n <= 0 || n > len(s.messages) {
+		n = len(s.messages)
+	}
+	result := make([]Message, n)
+	copy(result, s.messages[len(s.messages)-n:])
+	return result, nil
+}
+
+func (s *MemoryStore) Before(beforeID string, limit int) ([]Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cut := len(s.messages)
+	for i, msg := range s.messages {
+		if msg.ID == beforeID {
+			cut = i
+			break
+		}
+	}
+	start := cut - limit
+	if start < 0 {
+		start = 0
+	}
+	result := make([]Message, cut-start)
+	copy(result, s.messages[start:cut])
+	return result, nil
+}
+
+// SQLStore 将消息持久化到关系型数据库中的 messages 表，
+// 适合已经在使用 MySQL/Postgres 等基础设施的部署。
+type SQLStore struct {
+	db *sql.DB
+}
+
+// NewSQLStore 使用一个已建立的 *sql.DB 连接创建 SQLStore。
+func NewSQLStore(db *sql.DB) *SQLStore {
+	return &SQLStore{db: db}
+}
+
+func (s *SQLStore) Append(msg Message) error {
+	_, err := s.db.Exec(
+		`INSERT INTO messages (id, sender, recipient, content, type, timestamp) VALUES (?, ?, ?, ?, ?, ?)`,
+		msg.ID, msg.Sender, msg.Recipient, msg.Content, msg.Type, msg.Timestamp,
+	)
+	if err != nil {
+		return fmt.Errorf("sql store: append: %w", err)
+	}
+	return nil
+}
+
+func (s *SQLStore) Tail(n int) ([]Message, error) {
+	rows, err := s.db.Query(
+		`SELECT id, sender, recipient, content, type, timestamp FROM messages ORDER BY timestamp DESC LIMIT ?`, n,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("sql store: tail: %w", err)
+	}
+	defer rows.Close()
+	return scanMessagesReversed(rows)
+}
+
+func (s *SQLStore) Before(beforeID string, limit int) ([]Message, error) {
+	rows, err := s.db.Query(
+		`SELECT id, sender, recipient, content, type, timestamp FROM messages
+		 WHERE timestamp < (SELECT timestamp FROM messages WHERE id = ?)
+		 ORDER BY timestamp DESC LIMIT ?`, beforeID, limit,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("sql store: before: %w", err)
+	}
+	defer rows.Close()
+	return scanMessagesReversed(rows)
+}
+
+func scanMessagesReversed(rows *sql.Rows) ([]Message, error) {
+	var messages []Message
+	for rows.Next() {
+		var msg Message
+		if err := rows.Scan(&msg.ID, &msg.Sender, &msg.Recipient, &msg.Content, &msg.Type, &msg.Timestamp); err != nil {
+			return nil, fmt.Errorf("sql store: scan: %w", err)
+		}
+		messages = append(messages, msg)
+	}
+	for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+		messages[i], messages[j] = messages[j], messages[i]
+	}
+	return messages, rows.Err()
+}
+
+// MongoStore 将消息持久化到 MongoDB 集合中，适合已经以文档形式存储聊天记录的部署。
+type MongoStore struct {
+	collection *mongo.Collection
+}
+
+// NewMongoStore 使用一个已建立的 *mongo.Collection 创建 MongoStore。
+func NewMongoStore(collection *mongo.Collection) *MongoStore {
+	return &MongoStore{collection: collection}
+}
+
+func (s *MongoStore) Append(msg Message) error {
+	ctx, cancel := defaultMongoContext()
+	defer cancel()
+	if _, err := s.collection.InsertOne(ctx, msg); err != nil {
+		return fmt.Errorf("mongo store: append: %w", err)
+	}
+	return nil
+}
+
+func (s *MongoStore) Tail(n int) ([]Message, error) {
+	ctx, cancel := defaultMongoContext()
+	defer cancel()
+	opts := mongoFindOptions(int64(n))
+	cursor, err := s.collection.Find(ctx, bson.M{}, opts)
+	if err != nil {
+		return nil, fmt.Errorf("mongo store: tail: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	var messages []Message
+	if err := cursor.All(ctx, &messages); err != nil {
+		return nil, fmt.Errorf("mongo store: tail: %w", err)
+	}
+	reverseMessages(messages)
+	return messages, nil
+}
+
+func (s *MongoStore) Before(beforeID string, limit int) ([]Message, error) {
+	ctx, cancel := defaultMongoContext()
+	defer cancel()
+	var marker Message
+	if err := s.collection.FindOne(ctx, bson.M{"id": beforeID}).Decode(&marker); err != nil {
+		return nil, fmt.Errorf("mongo store: before: %w", err)
+	}
+
+	opts := mongoFindOptions(int64(limit))
+	cursor, err := s.collection.Find(ctx, bson.M{"timestamp": bson.M{"$lt": marker.Timestamp}}, opts)
+	if err != nil {
+		return nil, fmt.Errorf("mongo store: before: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	var messages []Message
+	if err := cursor.All(ctx, &messages); err != nil {
+		return nil, fmt.Errorf("mongo store: before: %w", err)
+	}
+	reverseMessages(messages)
+	return messages, nil
+}
+
+func reverseMessages(messages []Message) {
+	for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+		messages[i], messages[j] = messages[j], messages[i]
+	}
+}
+
+func defaultMongoContext() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), 5*time.Second)
+}
+
+func mongoFindOptions(limit int64) *options.FindOptions {
+	return options.Find().SetSort(bson.M{"timestamp": -1}).SetLimit(limit)
+}
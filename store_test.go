@@ -0,0 +1,70 @@
+package main
+
+import "testing"
+
+func TestMemoryStoreAppendTrimsToCapacity(t *testing.T) {
+	store := NewMemoryStore(3)
+	for i := 0; i < 5; i++ {
+		store.Append(Message{ID: string(rune('a' + i))})
+	}
+
+	messages, err := store.Tail(10)
+	if err != nil {
+		t.Fatalf("Tail returned error: %v", err)
+	}
+	if len(messages) != 3 {
+		t.Fatalf("got %d messages, want 3 (capacity)", len(messages))
+	}
+
+	want := []string{"c", "d", "e"}
+	for i, msg := range messages {
+		if msg.ID != want[i] {
+			t.Errorf("messages[%d].ID = %q, want %q", i, msg.ID, want[i])
+		}
+	}
+}
+
+func TestMemoryStoreTailLessThanAvailable(t *testing.T) {
+	store := NewMemoryStore(10)
+	store.Append(Message{ID: "a"})
+	store.Append(Message{ID: "b"})
+	store.Append(Message{ID: "c"})
+
+	messages, err := store.Tail(2)
+	if err != nil {
+		t.Fatalf("Tail returned error: %v", err)
+	}
+	if len(messages) != 2 || messages[0].ID != "b" || messages[1].ID != "c" {
+		t.Fatalf("got %+v, want [b c]", messages)
+	}
+}
+
+func TestMemoryStoreBeforePaginates(t *testing.T) {
+	store := NewMemoryStore(10)
+	for _, id := range []string{"a", "b", "c", "d", "e"} {
+		store.Append(Message{ID: id})
+	}
+
+	messages, err := store.Before("d", 2)
+	if err != nil {
+		t.Fatalf("Before returned error: %v", err)
+	}
+	if len(messages) != 2 || messages[0].ID != "b" || messages[1].ID != "c" {
+		t.Fatalf("got %+v, want [b c]", messages)
+	}
+}
+
+func TestMemoryStoreBeforeUnknownIDReturnsMostRecent(t *testing.T) {
+	store := NewMemoryStore(10)
+	for _, id := range []string{"a", "b", "c"} {
+		store.Append(Message{ID: id})
+	}
+
+	messages, err := store.Before("does-not-exist", 2)
+	if err != nil {
+		t.Fatalf("Before returned error: %v", err)
+	}
+	if len(messages) != 2 || messages[0].ID != "b" || messages[1].ID != "c" {
+		t.Fatalf("got %+v, want [b c]", messages)
+	}
+}
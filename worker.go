@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gorilla/websocket"
+	"github.com/wangheng-123/real_time_chat_app/metrics"
+)
+
+// MessageTypeWork 标记一条经由 manager.workQueue 分发的任务消息，与普通 chat 消息区分开。
+const MessageTypeWork = "work"
+
+// wsWorkerPage 把连接注册为 pool 查询参数指定的工作者池中的一个工作者，而不是加入
+// 广播池：manager.workQueue 上发布的任务会以轮询方式投递给池中恰好一个工作者。
+// 除此之外复用和 wsPage 相同的认证、升级和读写 goroutine 流程。
+func wsWorkerPage(res http.ResponseWriter, req *http.Request) {
+	userID, claims, err := authenticator.Authenticate(req)
+	if err != nil {
+		logger.Warn("worker authentication failed", "remote_addr", req.RemoteAddr, "error", err)
+		http.Error(res, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	pool := req.URL.Query().Get("pool")
+	if pool == "" {
+		http.Error(res, "missing pool query parameter", http.StatusBadRequest)
+		return
+	}
+
+	conn, error := (&websocket.Upgrader{CheckOrigin: func(r *http.Request) bool { return true }}).Upgrade(res, req, nil)
+	if error != nil {
+		metrics.UpgradeErrorsTotal.Inc()
+		logger.Error("worker websocket upgrade failed", "client_id", userID, "remote_addr", req.RemoteAddr, "error", error)
+		http.NotFound(res, req)
+		return
+	}
+	client := &Client{id: userID, socket: conn, send: make(chan []byte, sendBufferSize), claims: claims, worker: true}
+	client.onClose = func() { manager.workerUnregister <- workerRegistration{Pool: pool, Client: client} }
+	logger.Info("worker connected", "client_id", client.id, "pool", pool, "remote_addr", req.RemoteAddr)
+	metrics.ConnectedClients.Inc()
+
+	manager.workerRegister <- workerRegistration{Pool: pool, Client: client}
+
+	go client.read()
+	go client.write()
+}
+
+// publishWork 把一条任务消息序列化后发布到 manager.workQueue，由 Hub 的主循环以轮询
+// 方式投递给目标 pool 中的下一个工作者。
+func publishWork(pool, content string) error {
+	msg := newMessage("", pool, content, MessageTypeWork)
+	jsonMessage, err := json.Marshal(&msg)
+	if err != nil {
+		return err
+	}
+	manager.workQueue <- workItem{Pool: pool, Message: jsonMessage}
+	return nil
+}
+
+// publishWorkRequest 是 handlePublishWork 的请求体，Pool 决定任务投递给哪个工作者池。
+type publishWorkRequest struct {
+	Pool    string `json:"pool"`
+	Content string `json:"content"`
+}
+
+// handlePublishWork 是 publishWork 唯一的对外入口：调用方 POST 一个
+// {"pool":"...","content":"..."} 请求体，任务就会以轮询方式投递给该 pool 中的下一个
+// 通过 /ws/worker 注册的工作者。要求调用方先通过 authenticator 认证，和 wsPage/
+// wsWorkerPage 保持一致。
+func handlePublishWork(res http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(res, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if _, _, err := authenticator.Authenticate(req); err != nil {
+		logger.Warn("publish work authentication failed", "remote_addr", req.RemoteAddr, "error", err)
+		http.Error(res, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var body publishWorkRequest
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil || body.Pool == "" {
+		http.Error(res, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if err := publishWork(body.Pool, body.Content); err != nil {
+		logger.Error("failed to publish work", "pool", body.Pool, "error", err)
+		http.Error(res, "failed to publish work", http.StatusInternalServerError)
+		return
+	}
+
+	res.WriteHeader(http.StatusAccepted)
+}